@@ -0,0 +1,190 @@
+// Package irc implements a hal.Broker for IRC networks following the
+// client protocol described in RFC 1459/2811/2812/2813. It supports TLS,
+// SASL PLAIN/EXTERNAL authentication with a NickServ IDENTIFY fallback,
+// channel auto-join driven by hal prefs, and reconnects with jittered
+// backoff on disconnect.
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// PLUGIN_NAME is used to namespace the prefs this broker reads/writes.
+const PLUGIN_NAME = "irc"
+
+// CHANNELS_PREF is the channel-scoped pref key containing a
+// comma-separated list of channels to auto-join on connect.
+const CHANNELS_PREF = "autojoin"
+
+// Config holds the settings for a single IRC network connection. It
+// implements hal.BrokerConfig.
+type Config struct {
+	Server       string // host:port
+	UseTLS       bool
+	Nick         string
+	User         string
+	RealName     string
+	Password     string // server password, sent via PASS
+	SaslUser     string
+	SaslPass     string
+	SaslMethod   string // "PLAIN" or "EXTERNAL", empty disables SASL
+	NickServPass string // fallback IDENTIFY password, used when SaslMethod is empty
+}
+
+// NewBroker creates a Broker from the config and the given instance name.
+// It satisfies hal.BrokerConfig.
+func (c Config) NewBroker(name string) hal.Broker {
+	b := Broker{
+		name:      name,
+		config:    c,
+		casemap:   casemapRFC1459,
+		roomNames: make(map[string]string),
+		roomIds:   make(map[string]string),
+	}
+
+	return &b
+}
+
+// Broker implements hal.Broker for a single IRC network connection.
+type Broker struct {
+	name   string
+	config Config
+
+	mut     sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	casemap func(string) string
+
+	curNick string // nick currently being registered, reset per connection
+
+	roomNames map[string]string // normalized channel id -> display name
+	roomIds   map[string]string // display name -> normalized channel id
+
+	out chan *hal.Evt
+}
+
+// Name returns the name the broker was instantiated with.
+func (b *Broker) Name() string {
+	return b.name
+}
+
+// Send writes a PRIVMSG to the room (channel or nick) targeted by evt.
+// A body that is already a CTCP ACTION ("\x01ACTION ...") is sent unchanged
+// as a single line; any other body is sent as one PRIVMSG per line.
+func (b *Broker) Send(evt hal.Evt) {
+	target := evt.RoomId
+	if target == "" {
+		target = b.RoomNameToId(evt.Room)
+	}
+
+	body := evt.Body
+	if strings.HasPrefix(body, "\x01ACTION ") {
+		b.raw(fmt.Sprintf("PRIVMSG %s :%s", target, body))
+		return
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		b.raw(fmt.Sprintf("PRIVMSG %s :%s", target, line))
+	}
+}
+
+// Stream connects to the configured network and processes incoming lines
+// until the connection drops, then reconnects with jittered exponential
+// backoff.
+func (b *Broker) Stream(out chan *hal.Evt) {
+	b.out = out
+
+	backoff := time.Second
+
+	for {
+		err := b.connectAndRun()
+		if err != nil {
+			log.Printf("irc: %s: connection error: %s\n", b.name, err)
+		}
+
+		log.Printf("irc: %s: reconnecting in %s\n", b.name, backoff)
+		time.Sleep(jitter(backoff))
+
+		if backoff < 2*time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// RoomIdToName returns the display name for a normalized channel id.
+func (b *Broker) RoomIdToName(id string) string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if name, exists := b.roomNames[id]; exists {
+		return name
+	}
+
+	return id
+}
+
+// RoomNameToId normalizes a channel name to a stable id using the
+// casemapping negotiated via ISUPPORT so that prefs keyed on the result
+// stay stable across case variations (e.g. "#Foo" and "#foo").
+func (b *Broker) RoomNameToId(name string) string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	id := b.casemap(name)
+	b.roomIds[name] = id
+	b.roomNames[id] = name
+
+	return id
+}
+
+// UserIdToName and UserNameToId are identity functions on IRC: nicks
+// double as both the id and the display name.
+func (b *Broker) UserIdToName(id string) string   { return id }
+func (b *Broker) UserNameToId(name string) string { return name }
+
+func (b *Broker) raw(line string) {
+	b.mut.Lock()
+	conn := b.conn
+	b.mut.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\r\n", line); err != nil {
+		log.Printf("irc: %s: write failed: %s\n", b.name, err)
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)*3/10+1))
+}
+
+// dial opens the TCP (optionally TLS-wrapped) connection to the
+// configured server.
+func (b *Broker) dial() (net.Conn, error) {
+	if b.config.UseTLS {
+		return tls.Dial("tcp", b.config.Server, &tls.Config{ServerName: serverName(b.config.Server)})
+	}
+
+	return net.Dial("tcp", b.config.Server)
+}
+
+func serverName(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+
+	return host
+}
@@ -0,0 +1,64 @@
+package irc
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// message is a parsed IRC protocol line: [:prefix] COMMAND params... [:trailing]
+type message struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Nick returns the nick portion of the message's prefix, e.g. for
+// "nick!user@host" it returns "nick". If there's no '!' it returns the
+// whole prefix, which covers server-originated lines.
+func (m *message) Nick() string {
+	if i := strings.Index(m.Prefix, "!"); i >= 0 {
+		return m.Prefix[:i]
+	}
+	return m.Prefix
+}
+
+// parseMessage parses a single raw IRC line. Returns nil for blank lines.
+func parseMessage(line string) *message {
+	if line == "" {
+		return nil
+	}
+
+	m := &message{}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return nil
+		}
+		m.Prefix = line[1:i]
+		line = line[i+1:]
+	}
+
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing := line[i+2:]
+		m.Params = append(strings.Fields(line[:i]), trailing)
+	} else {
+		m.Params = strings.Fields(line)
+	}
+
+	if len(m.Params) == 0 {
+		return nil
+	}
+
+	m.Command = m.Params[0]
+	m.Params = m.Params[1:]
+
+	return m
+}
+
+// saslPlain builds the base64-encoded AUTHENTICATE payload for
+// SASL PLAIN: authzid \0 authcid \0 passwd, with an empty authzid.
+func saslPlain(user, pass string) string {
+	raw := "\x00" + user + "\x00" + pass
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
@@ -0,0 +1,93 @@
+package irc
+
+import "testing"
+
+func TestParseMessagePing(t *testing.T) {
+	m := parseMessage("PING :irc.example.com")
+	if m == nil {
+		t.Fatal("expected a parsed message, got nil")
+	}
+	if m.Command != "PING" {
+		t.Errorf("Command = %q, want PING", m.Command)
+	}
+	if len(m.Params) != 1 || m.Params[0] != "irc.example.com" {
+		t.Errorf("Params = %v, want [irc.example.com]", m.Params)
+	}
+}
+
+func TestParseMessagePrivmsgWithPrefix(t *testing.T) {
+	m := parseMessage(":alice!alice@example.com PRIVMSG #chan :hello there")
+	if m == nil {
+		t.Fatal("expected a parsed message, got nil")
+	}
+	if m.Prefix != "alice!alice@example.com" {
+		t.Errorf("Prefix = %q, want alice!alice@example.com", m.Prefix)
+	}
+	if m.Nick() != "alice" {
+		t.Errorf("Nick() = %q, want alice", m.Nick())
+	}
+	if m.Command != "PRIVMSG" {
+		t.Errorf("Command = %q, want PRIVMSG", m.Command)
+	}
+	if len(m.Params) != 2 || m.Params[0] != "#chan" || m.Params[1] != "hello there" {
+		t.Errorf("Params = %v, want [#chan, hello there]", m.Params)
+	}
+}
+
+func TestParseMessageBlank(t *testing.T) {
+	if m := parseMessage(""); m != nil {
+		t.Errorf("expected nil for a blank line, got %+v", m)
+	}
+}
+
+func TestParseMessageServerOnlyPrefix(t *testing.T) {
+	m := parseMessage(":irc.example.com 001 hal :Welcome")
+	if m == nil {
+		t.Fatal("expected a parsed message, got nil")
+	}
+	if m.Nick() != "irc.example.com" {
+		t.Errorf("Nick() = %q, want irc.example.com (no '!' in prefix)", m.Nick())
+	}
+}
+
+func TestParseCTCPAction(t *testing.T) {
+	action, ok := parseCTCPAction("\x01ACTION waves hello\x01")
+	if !ok {
+		t.Fatal("expected parseCTCPAction to recognize a CTCP ACTION")
+	}
+	if action != "waves hello" {
+		t.Errorf("action = %q, want %q", action, "waves hello")
+	}
+
+	if _, ok := parseCTCPAction("just a normal message"); ok {
+		t.Error("expected parseCTCPAction to reject a plain message")
+	}
+}
+
+func TestCasemapRFC1459(t *testing.T) {
+	cases := map[string]string{
+		"#Foo":  "#foo",
+		"Bar[]": "bar{}",
+		"A\\~B": "a|^b",
+	}
+
+	for in, want := range cases {
+		if got := casemapRFC1459(in); got != want {
+			t.Errorf("casemapRFC1459(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCasemapASCII(t *testing.T) {
+	if got := casemapASCII("#Foo[]"); got != "#foo[]" {
+		t.Errorf("casemapASCII(%q) = %q, want %q", "#Foo[]", got, "#foo[]")
+	}
+}
+
+func TestSaslPlain(t *testing.T) {
+	// base64 of "\x00alice\x00hunter2" (empty authzid, authcid, passwd)
+	want := "AGFsaWNlAGh1bnRlcjI="
+	if got := saslPlain("alice", "hunter2"); got != want {
+		t.Errorf("saslPlain() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,233 @@
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// connectAndRun dials the server, registers, authenticates, joins the
+// configured channels and then reads lines until the connection fails.
+func (b *Broker) connectAndRun() error {
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	b.mut.Lock()
+	b.conn = conn
+	b.reader = bufio.NewReader(conn)
+	b.mut.Unlock()
+
+	b.curNick = b.config.Nick
+
+	if err := b.register(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := b.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		b.handleLine(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// register sends CAP REQ :sasl (when SASL is configured), PASS, NICK and
+// USER, and returns without waiting for a reply. SASL negotiation and the
+// autojoin on RPL_WELCOME (001) are driven by handleLine.
+func (b *Broker) register() error {
+	if b.config.SaslMethod != "" {
+		b.raw("CAP REQ :sasl")
+	}
+
+	if b.config.Password != "" {
+		b.raw(fmt.Sprintf("PASS %s", b.config.Password))
+	}
+
+	b.raw(fmt.Sprintf("NICK %s", b.curNick))
+	user := b.config.User
+	if user == "" {
+		user = b.config.Nick
+	}
+	b.raw(fmt.Sprintf("USER %s 0 * :%s", user, b.config.RealName))
+
+	return nil
+}
+
+// handleLine parses a single server line and dispatches on its command.
+func (b *Broker) handleLine(line string) {
+	msg := parseMessage(line)
+	if msg == nil {
+		return
+	}
+
+	switch msg.Command {
+	case "PING":
+		b.raw("PONG :" + strings.Join(msg.Params, " "))
+	case "CAP":
+		b.handleCap(msg)
+	case "AUTHENTICATE":
+		b.handleAuthenticate(msg)
+	case "903", "904": // SASL success/failure
+		b.raw("CAP END")
+	case "001": // RPL_WELCOME, registration complete
+		b.identifyNickServ()
+		b.autojoin()
+	case "005": // RPL_ISUPPORT
+		b.handleISupport(msg)
+	case "433": // ERR_NICKNAMEINUSE
+		b.curNick += "_"
+		b.raw(fmt.Sprintf("NICK %s", b.curNick))
+	case "PRIVMSG":
+		b.handlePrivmsg(msg)
+	}
+}
+
+// identifyNickServ sends a NickServ IDENTIFY when SASL isn't configured
+// (SaslMethod is empty) and NickServPass is set. It is not sent when SASL
+// is configured but the network refuses or fails it.
+func (b *Broker) identifyNickServ() {
+	if b.config.SaslMethod != "" || b.config.NickServPass == "" {
+		return
+	}
+
+	b.raw(fmt.Sprintf("PRIVMSG NickServ :IDENTIFY %s", b.config.NickServPass))
+}
+
+func (b *Broker) handleCap(msg *message) {
+	if len(msg.Params) < 2 {
+		return
+	}
+
+	switch msg.Params[1] {
+	case "ACK":
+		b.raw(fmt.Sprintf("AUTHENTICATE %s", b.config.SaslMethod))
+	case "NAK":
+		b.raw("CAP END")
+	}
+}
+
+func (b *Broker) handleAuthenticate(msg *message) {
+	switch b.config.SaslMethod {
+	case "EXTERNAL":
+		b.raw("AUTHENTICATE +")
+	case "PLAIN":
+		b.raw("AUTHENTICATE " + saslPlain(b.config.SaslUser, b.config.SaslPass))
+	}
+}
+
+func (b *Broker) handleISupport(msg *message) {
+	for _, param := range msg.Params {
+		if strings.HasPrefix(param, "CASEMAPPING=") {
+			var casemap func(string) string
+			switch strings.TrimPrefix(param, "CASEMAPPING=") {
+			case "ascii":
+				casemap = casemapASCII
+			case "rfc1459-strict":
+				casemap = casemapRFC1459
+			default:
+				casemap = casemapRFC1459
+			}
+
+			b.mut.Lock()
+			b.casemap = casemap
+			b.mut.Unlock()
+		}
+	}
+}
+
+// handlePrivmsg translates a PRIVMSG into a hal.Evt, rewriting CTCP
+// ACTION payloads into the normalized "* nick does a thing" form.
+func (b *Broker) handlePrivmsg(msg *message) {
+	if len(msg.Params) < 2 {
+		return
+	}
+
+	target := msg.Params[0]
+	body := msg.Params[1]
+	nick := msg.Nick()
+
+	if action, ok := parseCTCPAction(body); ok {
+		body = fmt.Sprintf("* %s %s", nick, action)
+	}
+
+	roomId := target
+	room := target
+	if strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&") {
+		roomId = b.RoomNameToId(target)
+		room = target
+	}
+
+	b.out <- &hal.Evt{
+		Body:   body,
+		Room:   room,
+		RoomId: roomId,
+		User:   nick,
+		UserId: nick,
+		Broker: b,
+		IsChat: true,
+	}
+}
+
+// autojoin joins the channels configured via the CHANNELS_PREF hal pref,
+// falling back to nothing if none have been set yet.
+func (b *Broker) autojoin() {
+	pref := hal.FindPrefs("", b.name, "", PLUGIN_NAME, CHANNELS_PREF).Broker(b.name).Plugin(PLUGIN_NAME)
+
+	for _, p := range pref {
+		if p.Key != CHANNELS_PREF || p.Value == "" {
+			continue
+		}
+
+		for _, channel := range strings.Split(p.Value, ",") {
+			channel = strings.TrimSpace(channel)
+			if channel != "" {
+				b.raw("JOIN " + channel)
+			}
+		}
+	}
+}
+
+// parseCTCPAction extracts the text from a CTCP ACTION payload, e.g.
+// "\x01ACTION waves\x01" -> "waves".
+func parseCTCPAction(body string) (string, bool) {
+	const prefix = "\x01ACTION "
+	const suffix = "\x01"
+
+	if strings.HasPrefix(body, prefix) && strings.HasSuffix(body, suffix) {
+		return strings.TrimSuffix(strings.TrimPrefix(body, prefix), suffix), true
+	}
+
+	return "", false
+}
+
+// casemapRFC1459 lowercases per RFC 1459, where {}|^ are the
+// lowercase equivalents of []\~.
+func casemapRFC1459(s string) string {
+	r := []rune(strings.ToLower(s))
+	for i, c := range r {
+		switch c {
+		case '[':
+			r[i] = '{'
+		case ']':
+			r[i] = '}'
+		case '\\':
+			r[i] = '|'
+		case '~':
+			r[i] = '^'
+		}
+	}
+	return string(r)
+}
+
+// casemapASCII is a plain ASCII lowercase, used when the network
+// advertises CASEMAPPING=ascii via ISUPPORT.
+func casemapASCII(s string) string {
+	return strings.ToLower(s)
+}
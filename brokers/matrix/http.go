@@ -0,0 +1,73 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// get issues an authenticated GET against the homeserver and decodes the
+// JSON response body into a map.
+func (b *Broker) get(path string, query url.Values) (map[string]interface{}, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("access_token", b.config.AccessToken)
+
+	u := b.config.HomeserverURL + path + "?" + query.Encode()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.do(req)
+}
+
+// put issues an authenticated PUT with a JSON-encoded body against the
+// homeserver and decodes the JSON response.
+func (b *Broker) put(path string, body interface{}) (map[string]interface{}, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	u := b.config.HomeserverURL + path + "?access_token=" + url.QueryEscape(b.config.AccessToken)
+
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return b.do(req)
+}
+
+func (b *Broker) do(req *http.Request) (map[string]interface{}, error) {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode >= 300 {
+		return out, fmt.Errorf("matrix: %s %s returned %d: %v", req.Method, req.URL.Path, resp.StatusCode, out)
+	}
+
+	return out, nil
+}
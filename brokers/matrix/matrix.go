@@ -0,0 +1,226 @@
+// Package matrix implements a hal.Broker on top of the Matrix
+// client-server API (https://matrix.org/docs/spec/client_server/latest).
+//
+// It speaks plain client-server HTTP: long-polling /sync for incoming
+// events and PUT /rooms/{roomId}/send/m.room.message/{txnId} for outgoing
+// ones. End-to-end encryption is not supported: encrypted (m.room.encrypted)
+// events are not decrypted and are dropped, and Send always posts an
+// unencrypted m.room.message. The broker does not join rooms itself; it
+// reads events from rooms the bot user has already joined.
+package matrix
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// DefaultSyncTimeout is how long a single /sync long-poll is allowed to
+// block on the homeserver before it is retried.
+const DefaultSyncTimeout = 30 * time.Second
+
+// Config holds the settings required to connect a bot user to a Matrix
+// homeserver. It implements hal.BrokerConfig.
+type Config struct {
+	HomeserverURL string // e.g. https://matrix.org
+	AccessToken   string
+	DeviceId      string // optional, only needed to distinguish sessions
+	UserId        string // e.g. @hal:example.com; not looked up when left empty
+}
+
+// NewBroker creates a Broker from the config and the given instance name.
+// It satisfies hal.BrokerConfig.
+func (c Config) NewBroker(name string) hal.Broker {
+	b := Broker{
+		name:      name,
+		config:    c,
+		client:    &http.Client{Timeout: DefaultSyncTimeout + (10 * time.Second)},
+		rooms:     make(map[string]string),
+		roomNames: make(map[string]string),
+		users:     make(map[string]string),
+		userNames: make(map[string]string),
+		txnCount:  0,
+	}
+
+	return &b
+}
+
+// Broker implements hal.Broker for a single Matrix homeserver connection.
+type Broker struct {
+	name   string
+	config Config
+	client *http.Client
+
+	mut       sync.Mutex
+	rooms     map[string]string // room id -> canonical alias/name
+	roomNames map[string]string // canonical alias/name -> room id
+	users     map[string]string // user id -> display name
+	userNames map[string]string // display name -> user id
+
+	nextBatch string
+	txnCount  uint64
+
+	out chan *hal.Evt
+}
+
+// Name returns the name the broker was instantiated with.
+func (b *Broker) Name() string {
+	return b.name
+}
+
+// Send publishes an event as an m.room.message in the room the event
+// targets.
+func (b *Broker) Send(evt hal.Evt) {
+	roomId := evt.RoomId
+	if roomId == "" {
+		roomId = b.RoomNameToId(evt.Room)
+	}
+
+	body := map[string]interface{}{
+		"msgtype": "m.text",
+		"body":    evt.Body,
+	}
+
+	txnId := b.nextTxnId()
+	path := fmt.Sprintf("/_matrix/client/r0/rooms/%s/send/m.room.message/%s", roomId, txnId)
+
+	if _, err := b.put(path, body); err != nil {
+		log.Printf("matrix: failed to send to room %q: %s\n", roomId, err)
+	}
+}
+
+// Stream starts the /sync long-poll loop and writes translated events to
+// out; it never returns. It reconnects with jittered backoff if the
+// connection to the homeserver is interrupted.
+func (b *Broker) Stream(out chan *hal.Evt) {
+	b.out = out
+
+	// Establish a since token before entering the long-poll loop so the
+	// first real sync doesn't replay every joined room's entire history
+	// as if it were live traffic.
+	if err := b.primeSync(); err != nil {
+		log.Printf("matrix: initial sync failed, the first sync will include recent room history: %s\n", err)
+	}
+
+	backoff := time.Second
+
+	for {
+		err := b.syncOnce()
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		log.Printf("matrix: sync failed, reconnecting in %s: %s\n", backoff, err)
+		time.Sleep(jitter(backoff))
+
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// RoomIdToName returns the canonical room alias/name for a Matrix room id,
+// populating the cache via the homeserver if it isn't already known.
+func (b *Broker) RoomIdToName(id string) string {
+	b.mut.Lock()
+	name, exists := b.rooms[id]
+	b.mut.Unlock()
+
+	if exists {
+		return name
+	}
+
+	name, err := b.lookupRoomName(id)
+	if err != nil {
+		log.Printf("matrix: could not resolve room name for %q: %s\n", id, err)
+		return id
+	}
+
+	b.mut.Lock()
+	b.rooms[id] = name
+	b.roomNames[name] = id
+	b.mut.Unlock()
+
+	return name
+}
+
+// RoomNameToId is the inverse of RoomIdToName.
+func (b *Broker) RoomNameToId(name string) string {
+	b.mut.Lock()
+	id, exists := b.roomNames[name]
+	b.mut.Unlock()
+
+	if exists {
+		return id
+	}
+
+	// aliases can be resolved directly via the directory API
+	id, err := b.resolveAlias(name)
+	if err != nil {
+		log.Printf("matrix: could not resolve room id for %q: %s\n", name, err)
+		return name
+	}
+
+	b.mut.Lock()
+	b.rooms[id] = name
+	b.roomNames[name] = id
+	b.mut.Unlock()
+
+	return id
+}
+
+// UserIdToName returns the display name for a Matrix user id.
+func (b *Broker) UserIdToName(id string) string {
+	b.mut.Lock()
+	name, exists := b.users[id]
+	b.mut.Unlock()
+
+	if exists {
+		return name
+	}
+
+	name, err := b.lookupDisplayName(id)
+	if err != nil {
+		log.Printf("matrix: could not resolve display name for %q: %s\n", id, err)
+		return id
+	}
+
+	b.mut.Lock()
+	b.users[id] = name
+	b.userNames[name] = id
+	b.mut.Unlock()
+
+	return name
+}
+
+// UserNameToId is the inverse of UserIdToName.
+func (b *Broker) UserNameToId(name string) string {
+	b.mut.Lock()
+	id, exists := b.userNames[name]
+	b.mut.Unlock()
+
+	if exists {
+		return id
+	}
+
+	return name
+}
+
+func (b *Broker) nextTxnId() string {
+	b.mut.Lock()
+	b.txnCount++
+	n := b.txnCount
+	b.mut.Unlock()
+
+	return fmt.Sprintf("hal-%d-%d", time.Now().Unix(), n)
+}
+
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
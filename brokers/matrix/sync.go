@@ -0,0 +1,229 @@
+package matrix
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/netflix/hal-9001/hal"
+)
+
+// matches matrix.to user pills, e.g. href="https://matrix.to/#/@alice:example.com"
+var matrixToUserPill = regexp.MustCompile(`https://matrix\.to/#/(@[^"?]+)`)
+
+// primeSync performs a single non-blocking sync (timeout=0) purely to
+// capture a starting next_batch token. Any events returned by this call
+// are discarded rather than translated, since they predate the bot
+// joining the stream and shouldn't be replayed as live traffic.
+func (b *Broker) primeSync() error {
+	resp, err := b.get("/_matrix/client/r0/sync", url.Values{"timeout": []string{"0"}})
+	if err != nil {
+		return err
+	}
+
+	if nb, ok := resp["next_batch"].(string); ok {
+		b.nextBatch = nb
+	}
+
+	return nil
+}
+
+// syncOnce performs a single long-poll against /sync, translates any new
+// timeline events into hal.Evt and writes them to b.out, then advances
+// the since token for the next call.
+func (b *Broker) syncOnce() error {
+	q := url.Values{}
+	q.Set("timeout", strconv.Itoa(int(DefaultSyncTimeout.Seconds()*1000)))
+	if b.nextBatch != "" {
+		q.Set("since", b.nextBatch)
+	}
+
+	resp, err := b.get("/_matrix/client/r0/sync", q)
+	if err != nil {
+		return err
+	}
+
+	if nb, ok := resp["next_batch"].(string); ok {
+		b.nextBatch = nb
+	}
+
+	rooms, ok := resp["rooms"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	join, ok := rooms["join"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	for roomId, roomIface := range join {
+		room, ok := roomIface.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		timeline, ok := room["timeline"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		events, ok := timeline["events"].([]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, eIface := range events {
+			e, ok := eIface.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			if evt := b.translateEvent(roomId, e); evt != nil {
+				b.out <- evt
+			}
+		}
+	}
+
+	return nil
+}
+
+// translateEvent converts a raw Matrix room event into a hal.Evt.
+// Only m.room.message events are forwarded; edits (m.replace relations)
+// are rewritten in place as a new body prefixed with "(edited) " since hal
+// has no native concept of message editing, and mentioned user ids are
+// pulled into Mentions via mentionedUserIds.
+func (b *Broker) translateEvent(roomId string, e map[string]interface{}) *hal.Evt {
+	etype, _ := e["type"].(string)
+	if etype != "m.room.message" {
+		return nil
+	}
+
+	sender, _ := e["sender"].(string)
+	content, _ := e["content"].(map[string]interface{})
+	if content == nil {
+		return nil
+	}
+
+	body, _ := content["body"].(string)
+	msgtype, _ := content["msgtype"].(string)
+
+	if rel, ok := content["m.relates_to"].(map[string]interface{}); ok {
+		if relType, _ := rel["rel_type"].(string); relType == "m.replace" {
+			if newContent, ok := content["m.new_content"].(map[string]interface{}); ok {
+				if newBody, ok := newContent["body"].(string); ok {
+					body = fmt.Sprintf("(edited) %s", newBody)
+				}
+			}
+		}
+	}
+
+	if msgtype == "m.emote" {
+		body = fmt.Sprintf("* %s %s", b.UserIdToName(sender), body)
+	}
+
+	evtId, _ := e["event_id"].(string)
+	mentions := mentionedUserIds(content)
+
+	return &hal.Evt{
+		ID:       evtId,
+		Body:     body,
+		Room:     b.RoomIdToName(roomId),
+		RoomId:   roomId,
+		User:     b.UserIdToName(sender),
+		UserId:   sender,
+		Mentions: mentions,
+		Broker:   b,
+		IsChat:   true,
+		Original: e,
+	}
+}
+
+// mentionedUserIds extracts the Matrix user ids mentioned in a message,
+// preferring the explicit m.mentions field (MSC3952) and falling back to
+// matrix.to user pills in formatted_body for clients that don't send it.
+func mentionedUserIds(content map[string]interface{}) []string {
+	if m, ok := content["m.mentions"].(map[string]interface{}); ok {
+		if ids, ok := m["user_ids"].([]interface{}); ok {
+			out := make([]string, 0, len(ids))
+			for _, id := range ids {
+				if s, ok := id.(string); ok {
+					out = append(out, s)
+				}
+			}
+			if len(out) > 0 {
+				return out
+			}
+		}
+	}
+
+	formatted, _ := content["formatted_body"].(string)
+	if formatted == "" {
+		return nil
+	}
+
+	matches := matrixToUserPill.FindAllStringSubmatch(formatted, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	out := make([]string, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m[1])
+	}
+
+	return out
+}
+
+// lookupRoomName resolves a room id to a human-friendly name, preferring
+// the m.room.canonical_alias state event and falling back to m.room.name.
+func (b *Broker) lookupRoomName(roomId string) (string, error) {
+	resp, err := b.get(fmt.Sprintf("/_matrix/client/r0/rooms/%s/state/m.room.canonical_alias", roomId), nil)
+	if err == nil {
+		if alias, ok := resp["alias"].(string); ok && alias != "" {
+			return alias, nil
+		}
+	}
+
+	resp, err = b.get(fmt.Sprintf("/_matrix/client/r0/rooms/%s/state/m.room.name", roomId), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if name, ok := resp["name"].(string); ok && name != "" {
+		return name, nil
+	}
+
+	return roomId, nil
+}
+
+// resolveAlias looks up a room alias via the directory API and returns
+// the backing room id.
+func (b *Broker) resolveAlias(alias string) (string, error) {
+	resp, err := b.get(fmt.Sprintf("/_matrix/client/r0/directory/room/%s", url.PathEscape(alias)), nil)
+	if err != nil {
+		return "", err
+	}
+
+	roomId, ok := resp["room_id"].(string)
+	if !ok {
+		return "", fmt.Errorf("matrix: no room_id in directory response for %q", alias)
+	}
+
+	return roomId, nil
+}
+
+// lookupDisplayName fetches the profile display name for a Matrix user id.
+func (b *Broker) lookupDisplayName(userId string) (string, error) {
+	resp, err := b.get(fmt.Sprintf("/_matrix/client/r0/profile/%s/displayname", url.PathEscape(userId)), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if name, ok := resp["displayname"].(string); ok && name != "" {
+		return name, nil
+	}
+
+	return userId, nil
+}
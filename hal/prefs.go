@@ -2,8 +2,11 @@ package hal
 
 import (
 	"bytes"
+	"context"
 	"log"
 	"strings"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // provides a persistent configuration store
@@ -41,22 +44,35 @@ CREATE TABLE IF NOT EXISTS prefs (
    "user-plugin");
 */
 
+// sqlxDB wraps SqlDB() in a *sqlx.DB so Pref's db-tagged fields can be
+// scanned directly instead of listing out every column by hand.
+func sqlxDB() *sqlx.DB {
+	driverName := "mysql"
+	if isSQLite() {
+		driverName = "sqlite3"
+	}
+
+	return sqlx.NewDb(SqlDB(), driverName)
+}
+
 // !prefs list --scope plugin --plugin autoresponder
 // !prefs get --scope channel --plugin autoresponder --channel CORE --key timezone
 // !prefs set --scope user --plugin autoresponder --channel CORE
 
 // Pref is a key/value pair associated with a combination of user, plugin,
-// borker, or channel.
+// borker, or channel. The db tags let sqlx scan rows directly onto the
+// struct instead of listing out each column in every rows.Scan() call.
 type Pref struct {
-	User    string
-	Plugin  string
-	Broker  string
-	Channel string
-	Key     string
-	Value   string
-	Default string
-	Success bool
-	Error   error
+	User    string `db:"user"`
+	Plugin  string `db:"plugin"`
+	Broker  string `db:"broker"`
+	Channel string `db:"channel"`
+	Key     string `db:"pkey"`
+	Value   string `db:"value"`
+	Version int64  `db:"version"`
+	Default string `db:"-"`
+	Success bool   `db:"-"`
+	Error   error  `db:"-"`
 }
 
 type Prefs []*Pref
@@ -141,7 +157,13 @@ func GetPluginPrefs(plugin string) Prefs {
 // Get retrieves a value from the database. If the database returns
 // an error, Success will be false and the Error field will be populated.
 func (in *Pref) Get() Pref {
-	prefs := in.get()
+	return in.GetContext(context.Background())
+}
+
+// GetContext is Get() with a context that's threaded through to the query
+// so callers (e.g. HTTP handlers, plugin timeouts) can cancel it.
+func (in *Pref) GetContext(ctx context.Context) Pref {
+	prefs := in.getContext(ctx)
 
 	if len(prefs) == 1 {
 		return *prefs[0]
@@ -157,25 +179,28 @@ func (in *Pref) Get() Pref {
 }
 
 func (in *Pref) get() Prefs {
+	return in.getContext(context.Background())
+}
+
+func (in *Pref) getContext(ctx context.Context) Prefs {
 	SqlInit(PREFS_TABLE)
+	migratePrefsVersion()
 
-	sql := `SELECT user,channel,broker,plugin,pkey,value
+	sql := `SELECT user,channel,broker,plugin,pkey,value,version
 	        FROM prefs
-	        WHERE user=?
-			  AND channel=?
-			  AND broker=?
-			  AND plugin=?`
-	params := []interface{}{&in.User, &in.Channel, &in.Broker, &in.Plugin}
+	        WHERE user=:user
+			  AND channel=:channel
+			  AND broker=:broker
+			  AND plugin=:plugin`
 
 	// only query by key if it's specified, otherwise get all keys for the selection
 	if in.Key != "" {
-		sql += " AND pkey=?"
-		params = append(params, &in.Key)
+		sql += " AND pkey=:pkey"
 	}
 
-	db := SqlDB()
+	db := sqlxDB()
 
-	rows, err := db.Query(sql, params...)
+	rows, err := db.NamedQueryContext(ctx, sql, in)
 	if err != nil {
 		log.Printf("Returning default due to SQL query failure: %s", err)
 		return Prefs{}
@@ -188,9 +213,7 @@ func (in *Pref) get() Prefs {
 	for rows.Next() {
 		p := *in
 
-		err := rows.Scan(&p.User, &p.Channel, &p.Broker, &p.Plugin, &p.Key, &p.Value)
-
-		if err != nil {
+		if err := rows.StructScan(&p); err != nil {
 			log.Printf("Returning default due to row iteration failure: %s", err)
 			p.Success = false
 			p.Value = in.Default
@@ -205,21 +228,23 @@ func (in *Pref) get() Prefs {
 
 // Set writes the value and returns a new struct with the new value.
 func (in *Pref) Set() Pref {
-	db := SqlDB()
+	return in.SetContext(context.Background())
+}
+
+// SetContext is Set() with a context that's threaded through to the write.
+func (in *Pref) SetContext(ctx context.Context) Pref {
+	db := sqlxDB()
 	SqlInit(PREFS_TABLE)
+	migratePrefsTimestamps()
+	migratePrefsVersion()
 
 	sql := `INSERT INTO prefs
-						(value,user,channel,broker,plugin,pkey)
-			VALUES (?,?,?,?,?,?)
+						(value,user,channel,broker,plugin,pkey,created_at,updated_at)
+			VALUES (:value,:user,:channel,:broker,:plugin,:pkey,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
 			ON DUPLICATE KEY
-			UPDATE value=?, user=?, channel=?, broker=?, plugin=?, pkey=?`
+			UPDATE value=:value, user=:user, channel=:channel, broker=:broker, plugin=:plugin, pkey=:pkey, updated_at=CURRENT_TIMESTAMP, version=version+1`
 
-	params := []interface{}{
-		&in.Value, &in.User, &in.Channel, &in.Broker, &in.Plugin, &in.Key,
-		&in.Value, &in.User, &in.Channel, &in.Broker, &in.Plugin, &in.Key,
-	}
-
-	_, err := db.Exec(sql, params...)
+	_, err := db.NamedExecContext(ctx, sql, in)
 	if err != nil {
 		out := *in
 		out.Success = false
@@ -227,7 +252,7 @@ func (in *Pref) Set() Pref {
 		return out
 	}
 
-	return in.Get()
+	return in.GetContext(ctx)
 }
 
 // Find retrieves all preferences from the database that match any field in the
@@ -236,7 +261,13 @@ func (in *Pref) Set() Pref {
 // so it can potentially match a lot of rows.
 // Returns an empty list and logs upon errors.
 func (p Pref) Find() Prefs {
+	return p.FindContext(context.Background())
+}
+
+// FindContext is Find() with a context that's threaded through to the query.
+func (p Pref) FindContext(ctx context.Context) Prefs {
 	SqlInit(PREFS_TABLE)
+	migratePrefsVersion()
 
 	fields := make([]string, 0)
 	params := make([]interface{}, 0)
@@ -266,7 +297,7 @@ func (p Pref) Find() Prefs {
 		params = append(params, p.Key)
 	}
 
-	q := bytes.NewBufferString("SELECT user,channel,broker,plugin,pkey,value\n")
+	q := bytes.NewBufferString("SELECT user,channel,broker,plugin,pkey,value,version\n")
 	q.WriteString("FROM prefs\n")
 
 	// TODO: maybe it's silly to make it easy for Find() to get all preferences
@@ -280,9 +311,9 @@ func (p Pref) Find() Prefs {
 
 	// TODO: add deterministic ordering at query time
 
-	db := SqlDB()
+	db := sqlxDB()
 	out := make(Prefs, 0)
-	rows, err := db.Query(q.String(), params...)
+	rows, err := db.QueryxContext(ctx, q.String(), params...)
 	if err != nil {
 		log.Println(q.String())
 		log.Printf("Query failed: %s", err)
@@ -292,7 +323,7 @@ func (p Pref) Find() Prefs {
 
 	for rows.Next() {
 		row := Pref{}
-		err = rows.Scan(&row.User, &row.Channel, &row.Broker, &row.Plugin, &row.Key, &row.Value)
+		err = rows.StructScan(&row)
 		// improbable in practice - follows previously mentioned conventions for errors
 		if err != nil {
 			log.Printf("Fetching a row failed: %s\n", err)
@@ -0,0 +1,266 @@
+package hal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// provides a structured query API over prefs, modeled on SCN's
+// MessageFilter: a struct of optional per-field matchers composed with a
+// single AND/OR combinator, plus opaque-cursor pagination.
+
+// Combinator selects how the fields of a PrefFilter are combined.
+type Combinator int
+
+const (
+	// CombineAnd requires every non-nil field to match (the default).
+	CombineAnd Combinator = iota
+	// CombineOr matches a row if any non-nil field matches.
+	CombineOr
+)
+
+// PREFS_TIMESTAMPS_MIGRATION_* add the created_at/updated_at columns
+// required for UpdatedAfter filtering and are safe to run repeatedly;
+// "duplicate column" errors from re-running them are ignored.
+//
+// SQLite rejects ADD COLUMN with a non-constant default (e.g.
+// CURRENT_TIMESTAMP) as soon as the table has any rows, so the columns
+// are added bare and then backfilled with a separate UPDATE.
+const PREFS_TIMESTAMPS_MIGRATION_CREATED = `ALTER TABLE prefs ADD COLUMN created_at TIMESTAMP`
+const PREFS_TIMESTAMPS_MIGRATION_UPDATED = `ALTER TABLE prefs ADD COLUMN updated_at TIMESTAMP`
+const PREFS_TIMESTAMPS_BACKFILL = `UPDATE prefs SET created_at=CURRENT_TIMESTAMP, updated_at=CURRENT_TIMESTAMP
+                                    WHERE created_at IS NULL OR updated_at IS NULL`
+
+var prefsTimestampsMigrated bool
+
+// PrefFilter describes a structured query against the prefs table. Every
+// field is optional (nil/empty means "don't filter on this"); non-empty
+// fields are composed using Combinator.
+type PrefFilter struct {
+	User    *string
+	Channel *string
+	Broker  *string
+	Plugin  *string
+
+	In    []string // matches pkey IN (...)
+	NotIn []string // matches pkey NOT IN (...)
+
+	Like *string // matches value LIKE %*Like%
+
+	UpdatedAfter *time.Time
+
+	Combinator Combinator
+}
+
+// prefsCursor is the opaque pagination token passed to/from SelectPrefs.
+// It's base64(json) so it can be handed to callers as an ordinary string;
+// the column values are only encoded, not hidden.
+//
+// Only forward pagination is implemented; there's no "prev" direction.
+// Callers that need to go back should re-run the original query.
+type prefsCursor struct {
+	LastUser    string `json:"last_user"`
+	LastChannel string `json:"last_channel"`
+	LastBroker  string `json:"last_broker"`
+	LastPlugin  string `json:"last_plugin"`
+	LastKey     string `json:"last_pkey"`
+}
+
+func encodeCursor(c prefsCursor) string {
+	buf, _ := json.Marshal(c)
+	return base64.URLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(token string) (*prefsCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	buf, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", err)
+	}
+
+	c := prefsCursor{}
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", err)
+	}
+
+	return &c, nil
+}
+
+// SelectPrefs runs a structured query built from filter and returns at
+// most pageSize rows in a deterministic order (user, channel, broker,
+// plugin, pkey), along with an opaque cursor for the next page. An empty
+// nextCursor means there are no more rows.
+func SelectPrefs(ctx context.Context, filter PrefFilter, pageSize int, cursor string) (Prefs, string, error) {
+	SqlInit(PREFS_TABLE)
+	migratePrefsTimestamps()
+	migratePrefsVersion()
+
+	cur, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	q, params := buildSelectPrefsQuery(filter, cur, pageSize)
+
+	db := SqlDB()
+	rows, err := db.QueryContext(ctx, q, params...)
+	if err != nil {
+		log.Println(q)
+		log.Printf("SelectPrefs query failed: %s\n", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	out := make(Prefs, 0, pageSize)
+
+	for rows.Next() {
+		row := Pref{}
+		if err := rows.Scan(&row.User, &row.Channel, &row.Broker, &row.Plugin, &row.Key, &row.Value, &row.Version); err != nil {
+			return nil, "", err
+		}
+		row.Success = true
+		out = append(out, &row)
+	}
+
+	nextCursor := ""
+	if len(out) > pageSize {
+		last := out[pageSize-1]
+		out = out[:pageSize]
+		nextCursor = encodeCursor(prefsCursor{
+			LastUser:    last.User,
+			LastChannel: last.Channel,
+			LastBroker:  last.Broker,
+			LastPlugin:  last.Plugin,
+			LastKey:     last.Key,
+		})
+	}
+
+	return out, nextCursor, nil
+}
+
+// buildSelectPrefsQuery builds the SQL and positional params for a
+// SelectPrefs call. It's pure (no DB access) so the AND/OR/In/NotIn/cursor
+// branches can be unit tested without a live database.
+func buildSelectPrefsQuery(filter PrefFilter, cur *prefsCursor, pageSize int) (string, []interface{}) {
+	fields := make([]string, 0)
+	params := make([]interface{}, 0)
+
+	addEq := func(col string, v *string) {
+		if v != nil && *v != "" {
+			fields = append(fields, col+"=?")
+			params = append(params, *v)
+		}
+	}
+
+	addEq("user", filter.User)
+	addEq("channel", filter.Channel)
+	addEq("broker", filter.Broker)
+	addEq("plugin", filter.Plugin)
+
+	if len(filter.In) > 0 {
+		placeholders := make([]string, len(filter.In))
+		for i, v := range filter.In {
+			placeholders[i] = "?"
+			params = append(params, v)
+		}
+		fields = append(fields, fmt.Sprintf("pkey IN (%s)", strings.Join(placeholders, ",")))
+	}
+
+	if len(filter.NotIn) > 0 {
+		placeholders := make([]string, len(filter.NotIn))
+		for i, v := range filter.NotIn {
+			placeholders[i] = "?"
+			params = append(params, v)
+		}
+		fields = append(fields, fmt.Sprintf("pkey NOT IN (%s)", strings.Join(placeholders, ",")))
+	}
+
+	if filter.Like != nil && *filter.Like != "" {
+		fields = append(fields, "value LIKE ?")
+		params = append(params, "%"+*filter.Like+"%")
+	}
+
+	if filter.UpdatedAfter != nil {
+		fields = append(fields, "updated_at > ?")
+		params = append(params, *filter.UpdatedAfter)
+	}
+
+	// The cursor boundary is a pagination floor, not a filter term: it must
+	// always be AND'd onto the (independently AND/OR-combined) filter
+	// predicate, never merged into filter.Combinator, or an OR-combined
+	// filter would match any row past the boundary regardless of whether
+	// it satisfies the filter.
+	var cursorClause string
+	if cur != nil {
+		cursorClause = "(user,channel,broker,plugin,pkey) > (?,?,?,?,?)"
+		params = append(params, cur.LastUser, cur.LastChannel, cur.LastBroker, cur.LastPlugin, cur.LastKey)
+	}
+
+	q := bytes.NewBufferString("SELECT user,channel,broker,plugin,pkey,value,version\nFROM prefs\n")
+
+	where := make([]string, 0, 2)
+
+	if len(fields) > 0 {
+		joiner := " AND "
+		if filter.Combinator == CombineOr {
+			joiner = " OR "
+		}
+		where = append(where, "("+strings.Join(fields, joiner)+")")
+	}
+
+	if cursorClause != "" {
+		where = append(where, cursorClause)
+	}
+
+	if len(where) > 0 {
+		q.WriteString("WHERE ")
+		q.WriteString(strings.Join(where, "\n  AND "))
+		q.WriteString("\n")
+	}
+
+	q.WriteString("ORDER BY user,channel,broker,plugin,pkey\n")
+	q.WriteString("LIMIT ?")
+	params = append(params, pageSize+1)
+
+	return q.String(), params
+}
+
+// migratePrefsTimestamps adds created_at/updated_at to the prefs table if
+// they aren't present yet. Safe to call repeatedly; errors from a column
+// that already exists are swallowed since most drivers don't support
+// "ADD COLUMN IF NOT EXISTS" uniformly.
+func migratePrefsTimestamps() {
+	if prefsTimestampsMigrated {
+		return
+	}
+
+	db := SqlDB()
+
+	if _, err := db.Exec(PREFS_TIMESTAMPS_MIGRATION_CREATED); err != nil && !isDuplicateColumn(err) {
+		log.Printf("migratePrefsTimestamps: failed to add created_at: %s\n", err)
+	}
+
+	if _, err := db.Exec(PREFS_TIMESTAMPS_MIGRATION_UPDATED); err != nil && !isDuplicateColumn(err) {
+		log.Printf("migratePrefsTimestamps: failed to add updated_at: %s\n", err)
+	}
+
+	if _, err := db.Exec(PREFS_TIMESTAMPS_BACKFILL); err != nil {
+		log.Printf("migratePrefsTimestamps: failed to backfill existing rows: %s\n", err)
+	}
+
+	prefsTimestampsMigrated = true
+}
+
+func isDuplicateColumn(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate column") || strings.Contains(msg, "duplicate column name")
+}
@@ -0,0 +1,120 @@
+package hal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeCursorRoundTrip(t *testing.T) {
+	in := prefsCursor{
+		LastUser:    "alice",
+		LastChannel: "CORE",
+		LastBroker:  "slack",
+		LastPlugin:  "uptime",
+		LastKey:     "foo",
+	}
+
+	token := encodeCursor(in)
+	if token == "" {
+		t.Fatal("expected a non-empty cursor token")
+	}
+
+	out, err := decodeCursor(token)
+	if err != nil {
+		t.Fatalf("decodeCursor returned an error: %s", err)
+	}
+
+	if *out != in {
+		t.Errorf("decodeCursor(encodeCursor(in)) = %+v, want %+v", *out, in)
+	}
+}
+
+func TestDecodeCursorEmptyToken(t *testing.T) {
+	out, err := decodeCursor("")
+	if err != nil {
+		t.Fatalf("expected no error for an empty token, got %s", err)
+	}
+	if out != nil {
+		t.Errorf("expected a nil cursor for an empty token, got %+v", out)
+	}
+}
+
+func TestDecodeCursorInvalidToken(t *testing.T) {
+	if _, err := decodeCursor("not valid base64!"); err == nil {
+		t.Error("expected an error for an invalid cursor token")
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestBuildSelectPrefsQueryDefaultsToAnd(t *testing.T) {
+	filter := PrefFilter{User: strPtr("alice"), Channel: strPtr("CORE")}
+
+	q, params := buildSelectPrefsQuery(filter, nil, 10)
+
+	if !strings.Contains(q, "user=? AND channel=?") {
+		t.Errorf("expected fields joined with AND by default, got query: %s", q)
+	}
+	if len(params) != 3 { // user, channel, limit
+		t.Errorf("expected 3 params (user, channel, limit), got %d: %v", len(params), params)
+	}
+}
+
+func TestBuildSelectPrefsQueryOr(t *testing.T) {
+	filter := PrefFilter{User: strPtr("alice"), Channel: strPtr("CORE"), Combinator: CombineOr}
+
+	q, _ := buildSelectPrefsQuery(filter, nil, 10)
+
+	if !strings.Contains(q, "user=? OR channel=?") {
+		t.Errorf("expected fields joined with OR, got query: %s", q)
+	}
+}
+
+func TestBuildSelectPrefsQueryCursorAlwaysAnded(t *testing.T) {
+	filter := PrefFilter{User: strPtr("alice"), Channel: strPtr("CORE"), Combinator: CombineOr}
+	cur := &prefsCursor{LastUser: "alice", LastChannel: "CORE", LastKey: "foo"}
+
+	q, params := buildSelectPrefsQuery(filter, cur, 10)
+
+	// the OR-combined filter clause must be parenthesized and the cursor
+	// boundary must be AND'd on afterwards, never folded into the OR.
+	if !strings.Contains(q, "(user=? OR channel=?)") {
+		t.Errorf("expected the filter clause to be parenthesized, got query: %s", q)
+	}
+	if !strings.Contains(q, ") AND (user,channel,broker,plugin,pkey) > (?,?,?,?,?)") &&
+		!strings.Contains(q, ")\n  AND (user,channel,broker,plugin,pkey) > (?,?,?,?,?)") {
+		t.Errorf("expected the cursor boundary to be AND'd onto the filter clause, got query: %s", q)
+	}
+
+	// user, channel, 5 cursor columns, limit
+	if len(params) != 8 {
+		t.Errorf("expected 8 params, got %d: %v", len(params), params)
+	}
+}
+
+func TestBuildSelectPrefsQueryInNotIn(t *testing.T) {
+	filter := PrefFilter{In: []string{"a", "b"}, NotIn: []string{"c"}}
+
+	q, params := buildSelectPrefsQuery(filter, nil, 5)
+
+	if !strings.Contains(q, "pkey IN (?,?)") {
+		t.Errorf("expected an IN clause with 2 placeholders, got query: %s", q)
+	}
+	if !strings.Contains(q, "pkey NOT IN (?)") {
+		t.Errorf("expected a NOT IN clause with 1 placeholder, got query: %s", q)
+	}
+	if len(params) != 4 { // a, b, c, limit
+		t.Errorf("expected 4 params, got %d: %v", len(params), params)
+	}
+}
+
+func TestBuildSelectPrefsQueryNoFilterNoCursor(t *testing.T) {
+	q, params := buildSelectPrefsQuery(PrefFilter{}, nil, 5)
+
+	if strings.Contains(q, "WHERE") {
+		t.Errorf("expected no WHERE clause for an empty filter, got query: %s", q)
+	}
+	if len(params) != 1 { // just the limit
+		t.Errorf("expected 1 param (limit), got %d: %v", len(params), params)
+	}
+}
@@ -0,0 +1,185 @@
+package hal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// provides full-text search over pref values, backed by a FTS index that
+// mirrors the canonical prefs table. Mirrors the approach SCN's
+// MessageFilter uses for full-text search over message bodies.
+
+// PREFS_FTS_TABLE_SQLITE creates an FTS5 virtual table over pkey/value and
+// the triggers that keep it in sync with INSERT/UPDATE/DELETE on prefs.
+const PREFS_FTS_TABLE_SQLITE = `
+CREATE VIRTUAL TABLE IF NOT EXISTS prefs_fts USING fts5(
+	pkey, value, content='prefs', content_rowid='rowid'
+);
+
+CREATE TRIGGER IF NOT EXISTS prefs_ai AFTER INSERT ON prefs BEGIN
+	INSERT INTO prefs_fts(rowid, pkey, value) VALUES (new.rowid, new.pkey, new.value);
+END;
+
+CREATE TRIGGER IF NOT EXISTS prefs_ad AFTER DELETE ON prefs BEGIN
+	INSERT INTO prefs_fts(prefs_fts, rowid, pkey, value) VALUES ('delete', old.rowid, old.pkey, old.value);
+END;
+
+CREATE TRIGGER IF NOT EXISTS prefs_au AFTER UPDATE ON prefs BEGIN
+	INSERT INTO prefs_fts(prefs_fts, rowid, pkey, value) VALUES ('delete', old.rowid, old.pkey, old.value);
+	INSERT INTO prefs_fts(rowid, pkey, value) VALUES (new.rowid, new.pkey, new.value);
+END;
+`
+
+// PREFS_FTS_TABLE_MYSQL adds a FULLTEXT index over pkey/value. MySQL
+// maintains FULLTEXT indexes automatically so no triggers are required.
+const PREFS_FTS_TABLE_MYSQL = `ALTER TABLE prefs ADD FULLTEXT INDEX prefs_fts (pkey, value)`
+
+var prefsFtsInitDone bool
+
+// SearchPrefs performs a full-text search for query against the pkey and
+// value columns of the prefs table, scoped by the non-empty fields set on
+// filter. Unlike Find(), every non-empty filter field must match (they are
+// AND-ed). An empty query returns every row matching the filter.
+func SearchPrefs(query string, filter Pref) Prefs {
+	ensurePrefsFTS()
+	migratePrefsVersion()
+
+	fields := make([]string, 0)
+	params := make([]interface{}, 0)
+
+	if filter.User != "" {
+		fields = append(fields, "p.user=?")
+		params = append(params, filter.User)
+	}
+	if filter.Channel != "" {
+		fields = append(fields, "p.channel=?")
+		params = append(params, filter.Channel)
+	}
+	if filter.Broker != "" {
+		fields = append(fields, "p.broker=?")
+		params = append(params, filter.Broker)
+	}
+	if filter.Plugin != "" {
+		fields = append(fields, "p.plugin=?")
+		params = append(params, filter.Plugin)
+	}
+	if filter.Key != "" {
+		fields = append(fields, "p.pkey=?")
+		params = append(params, filter.Key)
+	}
+
+	var q string
+
+	if isSQLite() {
+		q = `SELECT p.user,p.channel,p.broker,p.plugin,p.pkey,p.value,p.version
+		     FROM prefs p
+		     JOIN prefs_fts ON prefs_fts.rowid = p.rowid`
+		if query != "" {
+			fields = append([]string{"prefs_fts MATCH ?"}, fields...)
+			params = append([]interface{}{query}, params...)
+		}
+	} else {
+		q = `SELECT p.user,p.channel,p.broker,p.plugin,p.pkey,p.value,p.version
+		     FROM prefs p`
+		if query != "" {
+			fields = append([]string{"MATCH(p.pkey,p.value) AGAINST (? IN BOOLEAN MODE)"}, fields...)
+			params = append([]interface{}{booleanModePhrase(query)}, params...)
+		}
+	}
+
+	if len(fields) > 0 {
+		q += "\nWHERE " + strings.Join(fields, "\n  AND ")
+	}
+
+	db := SqlDB()
+	out := make(Prefs, 0)
+
+	rows, err := db.Query(q, params...)
+	if err != nil {
+		log.Println(q)
+		log.Printf("SearchPrefs query failed: %s\n", err)
+		return out
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := Pref{}
+		err = rows.Scan(&row.User, &row.Channel, &row.Broker, &row.Plugin, &row.Key, &row.Value, &row.Version)
+		if err != nil {
+			log.Printf("SearchPrefs: fetching a row failed: %s\n", err)
+			row.Error = err
+			row.Success = false
+		} else {
+			row.Success = true
+		}
+
+		out = append(out, &row)
+	}
+
+	return out
+}
+
+// ensurePrefsFTS creates the prefs_fts table/triggers (sqlite) or the
+// FULLTEXT index (MySQL) the first time SearchPrefs is called, and
+// backfills it from any rows already in prefs.
+func ensurePrefsFTS() {
+	SqlInit(PREFS_TABLE)
+
+	if prefsFtsInitDone {
+		return
+	}
+
+	db := SqlDB()
+
+	if isSQLite() {
+		// statements are separated by blank lines; the trigger bodies
+		// contain ';' so the script can't be split on ';'
+		for _, stmt := range strings.Split(PREFS_FTS_TABLE_SQLITE, "\n\n") {
+			stmt = strings.TrimSpace(stmt)
+			if stmt == "" {
+				continue
+			}
+			if _, err := db.Exec(stmt); err != nil {
+				log.Printf("SearchPrefs: failed to initialize prefs_fts: %s\n", err)
+				return
+			}
+		}
+
+		// backfill any rows that predate the triggers; prefs_fts is an
+		// external-content table, so a rowid scan of it reads prefs itself
+		// and only 'rebuild' repopulates the index from existing rows
+		backfill := `INSERT INTO prefs_fts(prefs_fts) VALUES ('rebuild')`
+		if _, err := db.Exec(backfill); err != nil {
+			log.Printf("SearchPrefs: failed to backfill prefs_fts: %s\n", err)
+			return
+		}
+	} else {
+		if _, err := db.Exec(PREFS_FTS_TABLE_MYSQL); err != nil {
+			// "Duplicate key name" is returned once the index already exists
+			if !strings.Contains(err.Error(), "Duplicate") {
+				log.Printf("SearchPrefs: failed to initialize prefs_fts index: %s\n", err)
+				return
+			}
+		}
+	}
+
+	prefsFtsInitDone = true
+}
+
+// booleanModePhrase wraps query as a quoted phrase so MySQL's BOOLEAN MODE
+// parser treats it as literal text instead of interpreting +, -, *, ", (, ),
+// ~, < and > as operators. This matters because the motivating use case —
+// substring searches over arbitrary pref values like hostnames or paths
+// (e.g. "web-01.example.com") — is exactly the kind of input that trips
+// those operators, most commonly a leading '-' silently excluding a term.
+func booleanModePhrase(query string) string {
+	return `"` + strings.ReplaceAll(query, `"`, `\"`) + `"`
+}
+
+// isSQLite reports whether the configured SqlDB() is a SQLite connection,
+// as opposed to MySQL, by inspecting the concrete driver type name.
+func isSQLite() bool {
+	db := SqlDB()
+	return strings.Contains(fmt.Sprintf("%T", db.Driver()), "sqlite")
+}
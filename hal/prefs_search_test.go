@@ -0,0 +1,20 @@
+package hal
+
+import "testing"
+
+func TestBooleanModePhrase(t *testing.T) {
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"web-01.example.com", `"web-01.example.com"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"+required -excluded", `"+required -excluded"`},
+	}
+
+	for _, c := range cases {
+		if got := booleanModePhrase(c.query); got != c.want {
+			t.Errorf("booleanModePhrase(%q) = %q, want %q", c.query, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package hal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// provides transactional multi-pref writes and optimistic concurrency on
+// top of the Version column, so plugins that read-modify-write a
+// structured value (e.g. an autoresponder ruleset stored as JSON) don't
+// silently clobber another user's change.
+
+// PREFS_VERSION_MIGRATION adds the version column used by CompareAndSet.
+// Safe to run repeatedly; "duplicate column" errors from re-running it
+// are ignored, same as the timestamp migrations.
+const PREFS_VERSION_MIGRATION = `ALTER TABLE prefs ADD COLUMN version BIGINT NOT NULL DEFAULT 0`
+
+var prefsVersionMigrated bool
+
+// ErrVersionMismatch is returned by CompareAndSet when the row was
+// modified since the version it was read at.
+var ErrVersionMismatch = errors.New("pref was modified since it was read, try again")
+
+func migratePrefsVersion() {
+	if prefsVersionMigrated {
+		return
+	}
+
+	db := SqlDB()
+
+	if _, err := db.Exec(PREFS_VERSION_MIGRATION); err != nil && !isDuplicateColumn(err) {
+		log.Printf("migratePrefsVersion: failed to add version: %s\n", err)
+	}
+
+	prefsVersionMigrated = true
+}
+
+// SetPrefs writes an entire batch of preferences atomically: either all of
+// them land or none do.
+func SetPrefs(prefs Prefs) error {
+	return prefs.SetPrefsContext(context.Background())
+}
+
+// SetPrefsContext is SetPrefs() with a context that's threaded through to
+// the transaction.
+func (prefs Prefs) SetPrefsContext(ctx context.Context) error {
+	SqlInit(PREFS_TABLE)
+	migratePrefsVersion()
+	migratePrefsTimestamps()
+
+	db := sqlxDB()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := prefs.setTxContext(ctx, tx.Tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetTx writes every pref in the list using the caller's transaction.
+// Rolling back the transaction on error is the caller's responsibility.
+func (prefs Prefs) SetTx(tx *sql.Tx) error {
+	return prefs.setTxContext(context.Background(), tx)
+}
+
+func (prefs Prefs) setTxContext(ctx context.Context, tx *sql.Tx) error {
+	sql := `INSERT INTO prefs
+						(value,user,channel,broker,plugin,pkey,created_at,updated_at)
+			VALUES (?,?,?,?,?,?,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+			ON DUPLICATE KEY
+			UPDATE value=?, version=version+1, updated_at=CURRENT_TIMESTAMP`
+
+	for _, p := range prefs {
+		params := []interface{}{
+			p.Value, p.User, p.Channel, p.Broker, p.Plugin, p.Key,
+			p.Value,
+		}
+
+		if _, err := tx.ExecContext(ctx, sql, params...); err != nil {
+			return fmt.Errorf("SetTx: failed to write pref %q: %s", p.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// CompareAndSet writes the pref's current Value only if the row's version
+// still matches oldVersion, failing cleanly (ErrVersionMismatch) if
+// another writer updated it first. On success the pref's Version is
+// advanced to match the row.
+func (in *Pref) CompareAndSet(oldVersion int64) error {
+	migratePrefsVersion()
+	migratePrefsTimestamps()
+
+	db := SqlDB()
+
+	sql := `UPDATE prefs SET value=?, version=version+1, updated_at=CURRENT_TIMESTAMP
+	        WHERE user=? AND channel=? AND broker=? AND plugin=? AND pkey=? AND version=?`
+
+	res, err := db.Exec(sql, in.Value, in.User, in.Channel, in.Broker, in.Plugin, in.Key, oldVersion)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrVersionMismatch
+	}
+
+	in.Version = oldVersion + 1
+	return nil
+}